@@ -0,0 +1,165 @@
+package wrapper
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// IdentityResolver resolves a human-meaningful identity (a user or machine
+// name) for a connecting peer. It's a Go-level extension point: an embedder
+// can supply an implementation backed by, for example, a Tailscale/tsnet
+// LocalClient, without this package depending on any specific identity
+// backend.
+type IdentityResolver interface {
+	ResolveIdentity(addr net.Addr) (identity string, ok bool)
+}
+
+// AuditConfig configures the audit-log subsystem. Syntax:
+//
+//	audit {
+//		file <path>
+//		logger <name>
+//		identity_field <name>
+//	}
+//
+// file and logger are mutually exclusive ways of choosing where audit
+// events are written; if neither is set, auditing is disabled.
+type AuditConfig struct {
+	// File, if set, is a path that audit events are appended to as JSON lines.
+	File string `json:"file,omitempty"`
+
+	// Logger, if set, names a sub-logger (under the wrapper's own logger)
+	// that audit events are written to instead of a dedicated file.
+	Logger string `json:"logger,omitempty"`
+
+	// IdentityField names the log field a resolved IdentityResolver result
+	// is recorded under. Defaults to "identity". This only renames the
+	// field; the identity itself still comes from the configured
+	// IdentityResolver, not from anything read off the connection.
+	IdentityField string `json:"identity_field,omitempty"`
+}
+
+// auditor emits structured Postgres connection lifecycle events.
+type auditor struct {
+	logger        *zap.Logger
+	identityField string
+	resolver      IdentityResolver
+
+	// file is the audit log file opened by newAuditor, if cfg.File was
+	// set. It's kept around solely so Cleanup can close it; the logger
+	// writes to it through its own zapcore.WriteSyncer.
+	file *os.File
+}
+
+// newAuditor builds an auditor from cfg, or returns a nil auditor if
+// auditing is disabled (neither cfg.File nor cfg.Logger is set).
+func newAuditor(base *zap.Logger, cfg AuditConfig, resolver IdentityResolver) (*auditor, error) {
+	if cfg.File == "" && cfg.Logger == "" {
+		return nil, nil
+	}
+
+	logger := base
+	var file *os.File
+	switch {
+	case cfg.File != "":
+		f, err := os.OpenFile(cfg.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("opening audit log file %q: %w", cfg.File, err)
+		}
+		core := zapcore.NewCore(
+			zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+			zapcore.AddSync(f),
+			zapcore.InfoLevel,
+		)
+		logger = zap.New(core)
+		file = f
+	case cfg.Logger != "":
+		logger = base.Named(cfg.Logger)
+	}
+
+	identityField := cfg.IdentityField
+	if identityField == "" {
+		identityField = "identity"
+	}
+
+	return &auditor{logger: logger, identityField: identityField, resolver: resolver, file: file}, nil
+}
+
+// Cleanup closes the audit log file, if one was opened. It's a no-op for
+// auditors configured with the logger option, since that sub-logger's
+// underlying writer is owned by Caddy's own logging config.
+func (a *auditor) Cleanup() error {
+	if a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// resolveIdentity returns a zap field carrying the resolved identity for
+// addr, or nil if no resolver is configured or no identity was found.
+func (a *auditor) resolveIdentity(addr net.Addr) *zap.Field {
+	if a.resolver == nil {
+		return nil
+	}
+	identity, ok := a.resolver.ResolveIdentity(addr)
+	if !ok {
+		return nil
+	}
+	field := zap.String(a.identityField, identity)
+	return &field
+}
+
+// accepted logs a connection acceptance.
+func (a *auditor) accepted(conn net.Conn) {
+	fields := []zap.Field{zap.String("remote_addr", conn.RemoteAddr().String())}
+	if f := a.resolveIdentity(conn.RemoteAddr()); f != nil {
+		fields = append(fields, *f)
+	}
+	a.logger.Info("postgres connection accepted", fields...)
+}
+
+// rejected logs a connection turned away by policy - a deny/allow list
+// mismatch or a rejected GSSENCRequest - before it ever got to negotiate
+// Postgres's SSL handshake. reason is a short, stable description of why.
+func (a *auditor) rejected(conn net.Conn, reason string) {
+	fields := []zap.Field{
+		zap.String("remote_addr", conn.RemoteAddr().String()),
+		zap.String("reason", reason),
+	}
+	if f := a.resolveIdentity(conn.RemoteAddr()); f != nil {
+		fields = append(fields, *f)
+	}
+	a.logger.Info("postgres connection rejected", fields...)
+}
+
+// startTLS logs whether the STARTTLS handshake was negotiated for the
+// connection, and the SNI seen in the client's ClientHello, if any.
+func (a *auditor) startTLS(conn net.Conn, negotiated bool, sni string) {
+	fields := []zap.Field{
+		zap.String("remote_addr", conn.RemoteAddr().String()),
+		zap.Bool("negotiated", negotiated),
+	}
+	if sni != "" {
+		fields = append(fields, zap.String("sni", sni))
+	}
+	a.logger.Info("postgres starttls", fields...)
+}
+
+// closed logs the end of a connection's lifecycle.
+func (a *auditor) closed(conn net.Conn, start time.Time, bytesIn, bytesOut int64, reason error) {
+	fields := []zap.Field{
+		zap.String("remote_addr", conn.RemoteAddr().String()),
+		zap.Duration("duration", time.Since(start)),
+		zap.Int64("bytes_in", bytesIn),
+		zap.Int64("bytes_out", bytesOut),
+	}
+	if reason != nil {
+		fields = append(fields, zap.Error(reason))
+	}
+	a.logger.Info("postgres connection closed", fields...)
+}
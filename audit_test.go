@@ -0,0 +1,265 @@
+package wrapper
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// fakeAddr is a minimal net.Addr for tests that don't need a real connection.
+type fakeAddr string
+
+func (a fakeAddr) Network() string { return "tcp" }
+func (a fakeAddr) String() string  { return string(a) }
+
+// fakeConn is a minimal net.Conn stand-in; only RemoteAddr is exercised by
+// the auditor.
+type fakeConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c fakeConn) RemoteAddr() net.Addr { return c.remote }
+
+// stubResolver resolves every address to identity, or resolves nothing if
+// identity is empty.
+type stubResolver struct {
+	identity string
+}
+
+func (r stubResolver) ResolveIdentity(net.Addr) (string, bool) {
+	if r.identity == "" {
+		return "", false
+	}
+	return r.identity, true
+}
+
+func TestNewAuditor(t *testing.T) {
+	t.Run("disabled when neither file nor logger is set", func(t *testing.T) {
+		a, err := newAuditor(zap.NewNop(), AuditConfig{}, nil)
+		if err != nil {
+			t.Fatalf("newAuditor: %v", err)
+		}
+		if a != nil {
+			t.Fatalf("auditor = %+v, want nil", a)
+		}
+	})
+
+	t.Run("file-backed", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		a, err := newAuditor(zap.NewNop(), AuditConfig{File: path}, nil)
+		if err != nil {
+			t.Fatalf("newAuditor: %v", err)
+		}
+		if a == nil {
+			t.Fatal("auditor = nil, want non-nil")
+		}
+		if a.file == nil {
+			t.Fatal("file-backed auditor should keep its *os.File around")
+		}
+
+		a.accepted(fakeConn{remote: fakeAddr("198.51.100.1:5432")})
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("reading audit log: %v", err)
+		}
+		if len(data) == 0 {
+			t.Fatal("expected at least one line written to the audit log file")
+		}
+	})
+
+	t.Run("invalid file path", func(t *testing.T) {
+		_, err := newAuditor(zap.NewNop(), AuditConfig{File: filepath.Join(t.TempDir(), "nope", "audit.log")}, nil)
+		if err == nil {
+			t.Fatal("expected an error opening a file in a nonexistent directory")
+		}
+	})
+
+	t.Run("named sub-logger", func(t *testing.T) {
+		core, logs := observer.New(zapcore.InfoLevel)
+		base := zap.New(core)
+
+		a, err := newAuditor(base, AuditConfig{Logger: "audit"}, nil)
+		if err != nil {
+			t.Fatalf("newAuditor: %v", err)
+		}
+		if a == nil {
+			t.Fatal("auditor = nil, want non-nil")
+		}
+		if a.file != nil {
+			t.Fatal("logger-backed auditor shouldn't have opened a file")
+		}
+
+		a.accepted(fakeConn{remote: fakeAddr("198.51.100.1:5432")})
+
+		entries := logs.All()
+		if len(entries) != 1 {
+			t.Fatalf("got %d log entries, want 1", len(entries))
+		}
+		if entries[0].LoggerName != "audit" {
+			t.Errorf("logger name = %q, want %q", entries[0].LoggerName, "audit")
+		}
+	})
+
+	t.Run("default identity field", func(t *testing.T) {
+		a, err := newAuditor(zap.NewNop(), AuditConfig{Logger: "audit"}, nil)
+		if err != nil {
+			t.Fatalf("newAuditor: %v", err)
+		}
+		if a.identityField != "identity" {
+			t.Errorf("identityField = %q, want %q", a.identityField, "identity")
+		}
+	})
+
+	t.Run("custom identity field", func(t *testing.T) {
+		a, err := newAuditor(zap.NewNop(), AuditConfig{Logger: "audit", IdentityField: "caller"}, nil)
+		if err != nil {
+			t.Fatalf("newAuditor: %v", err)
+		}
+		if a.identityField != "caller" {
+			t.Errorf("identityField = %q, want %q", a.identityField, "caller")
+		}
+	})
+}
+
+func TestAuditorCleanup(t *testing.T) {
+	t.Run("closes the file when file-backed", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		a, err := newAuditor(zap.NewNop(), AuditConfig{File: path}, nil)
+		if err != nil {
+			t.Fatalf("newAuditor: %v", err)
+		}
+		if err := a.Cleanup(); err != nil {
+			t.Fatalf("Cleanup: %v", err)
+		}
+		if err := a.file.Close(); !errors.Is(err, os.ErrClosed) {
+			t.Fatalf("file should already be closed, got: %v", err)
+		}
+	})
+
+	t.Run("no-op for a logger-backed auditor", func(t *testing.T) {
+		a, err := newAuditor(zap.NewNop(), AuditConfig{Logger: "audit"}, nil)
+		if err != nil {
+			t.Fatalf("newAuditor: %v", err)
+		}
+		if err := a.Cleanup(); err != nil {
+			t.Fatalf("Cleanup: %v", err)
+		}
+	})
+}
+
+func TestAuditorEvents(t *testing.T) {
+	newObserved := func(resolver IdentityResolver) (*auditor, *observer.ObservedLogs) {
+		core, logs := observer.New(zapcore.InfoLevel)
+		return &auditor{logger: zap.New(core), identityField: "identity", resolver: resolver}, logs
+	}
+
+	conn := fakeConn{remote: fakeAddr("198.51.100.1:5432")}
+
+	t.Run("accepted", func(t *testing.T) {
+		a, logs := newObserved(nil)
+		a.accepted(conn)
+
+		entries := logs.All()
+		if len(entries) != 1 {
+			t.Fatalf("got %d entries, want 1", len(entries))
+		}
+		if got := entries[0].Message; got != "postgres connection accepted" {
+			t.Errorf("message = %q", got)
+		}
+		if got := entries[0].ContextMap()["remote_addr"]; got != conn.RemoteAddr().String() {
+			t.Errorf("remote_addr = %v, want %v", got, conn.RemoteAddr().String())
+		}
+	})
+
+	t.Run("accepted with resolved identity", func(t *testing.T) {
+		a, logs := newObserved(stubResolver{identity: "alice"})
+		a.accepted(conn)
+
+		got := logs.All()[0].ContextMap()["identity"]
+		if got != "alice" {
+			t.Errorf("identity = %v, want %q", got, "alice")
+		}
+	})
+
+	t.Run("accepted without a resolved identity", func(t *testing.T) {
+		a, logs := newObserved(stubResolver{})
+		a.accepted(conn)
+
+		if _, ok := logs.All()[0].ContextMap()["identity"]; ok {
+			t.Error("expected no identity field when the resolver found nothing")
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		a, logs := newObserved(nil)
+		a.rejected(conn, "denied")
+
+		entries := logs.All()
+		if len(entries) != 1 {
+			t.Fatalf("got %d entries, want 1", len(entries))
+		}
+		if got := entries[0].Message; got != "postgres connection rejected" {
+			t.Errorf("message = %q", got)
+		}
+		if got := entries[0].ContextMap()["reason"]; got != "denied" {
+			t.Errorf("reason = %v, want %q", got, "denied")
+		}
+	})
+
+	t.Run("startTLS negotiated with sni", func(t *testing.T) {
+		a, logs := newObserved(nil)
+		a.startTLS(conn, true, "tenant-a.db.example.com")
+
+		ctx := logs.All()[0].ContextMap()
+		if got := ctx["negotiated"]; got != true {
+			t.Errorf("negotiated = %v, want true", got)
+		}
+		if got := ctx["sni"]; got != "tenant-a.db.example.com" {
+			t.Errorf("sni = %v", got)
+		}
+	})
+
+	t.Run("startTLS without sni omits the field", func(t *testing.T) {
+		a, logs := newObserved(nil)
+		a.startTLS(conn, false, "")
+
+		if _, ok := logs.All()[0].ContextMap()["sni"]; ok {
+			t.Error("expected no sni field when none was negotiated")
+		}
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		a, logs := newObserved(nil)
+		start := time.Now().Add(-time.Second)
+		a.closed(conn, start, 10, 20, nil)
+
+		ctx := logs.All()[0].ContextMap()
+		if got := ctx["bytes_in"]; got != int64(10) {
+			t.Errorf("bytes_in = %v, want 10", got)
+		}
+		if got := ctx["bytes_out"]; got != int64(20) {
+			t.Errorf("bytes_out = %v, want 20", got)
+		}
+		if _, ok := ctx["error"]; ok {
+			t.Error("expected no error field for a nil reason")
+		}
+	})
+
+	t.Run("closed with a reason records the error", func(t *testing.T) {
+		a, logs := newObserved(nil)
+		a.closed(conn, time.Now(), 0, 0, errors.New("connection reset"))
+
+		if got := logs.All()[0].ContextMap()["error"]; got != "connection reset" {
+			t.Errorf("error = %v, want %q", got, "connection reset")
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package wrapper
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// peerAddr extracts conn's remote address as a netip.Addr, or the zero
+// value if it can't be determined (e.g. a non-IP network).
+func peerAddr(conn net.Conn) netip.Addr {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr
+}
+
+// parsePrefixList parses a list of Caddyfile entries as either single IPs
+// or CIDR blocks, normalizing single IPs to host (/32 or /128) prefixes.
+func parsePrefixList(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %q as a CIDR block: %w", entry, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %q as an IP address: %w", entry, err)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// containsAddr reports whether addr falls within any of prefixes.
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
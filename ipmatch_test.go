@@ -0,0 +1,52 @@
+package wrapper
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParsePrefixList(t *testing.T) {
+	prefixes, err := parsePrefixList([]string{"192.0.2.1", "198.51.100.0/24", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("parsePrefixList: %v", err)
+	}
+	if len(prefixes) != 3 {
+		t.Fatalf("got %d prefixes, want 3", len(prefixes))
+	}
+	if prefixes[0].Bits() != 32 {
+		t.Errorf("single IPv4 should normalize to a /32, got /%d", prefixes[0].Bits())
+	}
+	if prefixes[2].Bits() != 128 {
+		t.Errorf("single IPv6 should normalize to a /128, got /%d", prefixes[2].Bits())
+	}
+
+	if _, err := parsePrefixList([]string{"not-an-ip"}); err == nil {
+		t.Error("expected an error for an invalid entry")
+	}
+}
+
+func TestContainsAddr(t *testing.T) {
+	prefixes, err := parsePrefixList([]string{"198.51.100.0/24", "2001:db8::/32"})
+	if err != nil {
+		t.Fatalf("parsePrefixList: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		addr netip.Addr
+		want bool
+	}{
+		{name: "in IPv4 block", addr: netip.MustParseAddr("198.51.100.42"), want: true},
+		{name: "outside IPv4 block", addr: netip.MustParseAddr("192.0.2.1"), want: false},
+		{name: "in IPv6 block", addr: netip.MustParseAddr("2001:db8::abcd"), want: true},
+		{name: "invalid addr", addr: netip.Addr{}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := containsAddr(prefixes, tt.addr); got != tt.want {
+				t.Errorf("containsAddr() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
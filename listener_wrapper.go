@@ -4,9 +4,12 @@ import (
 	"bufio"
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"net"
+	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
@@ -20,8 +23,33 @@ var (
 
 	// PostgresStartTLSReply is the single byte 'S' sent back to clients to indicate SSL is supported
 	PostgresStartTLSReply = []byte{83} // 'S' byte
+
+	// PostgresGSSENCMsg is the message sent by PostgreSQL clients (built with
+	// GSSAPI support) to request GSS-encrypted transport, ahead of the SSL
+	// request. It consists of a 4-byte length (8) followed by the GSSAPI
+	// request code (80877104).
+	PostgresGSSENCMsg = []byte{0, 0, 0, 8, 4, 210, 22, 48} // int32(8) + int32(80877104)
+
+	// PostgresGSSENCReply is the single byte 'N' sent back to clients to
+	// indicate GSS encryption is not supported.
+	PostgresGSSENCReply = []byte{78} // 'N' byte
+)
+
+// GSSAPI policy values for the ListenerWrapper.GSSAPI field.
+const (
+	GSSAPIAllow       = "allow"
+	GSSAPIReject      = "reject"
+	GSSAPIPassthrough = "passthrough"
 )
 
+// routeDialTimeout bounds how long routeConnection waits to dial a
+// route's upstream. It's independent of the listener's (much shorter)
+// peek timeout, since dialing a real backend legitimately takes longer
+// than peeking a few already-buffered bytes, but a down or black-holed
+// upstream must never be allowed to block the shared accept loop
+// indefinitely.
+const routeDialTimeout = 5 * time.Second
+
 // ListenerWrapper provides PostgreSQL SSL support to Caddy by implementing
 // the caddy.ListenerWrapper interface. It detects PostgreSQL's SSL handshake
 // request and responds correctly to enable TLS for PostgreSQL connections.
@@ -29,13 +57,49 @@ type ListenerWrapper struct {
 	// Timeout specifies how long to wait when peeking at connections
 	Timeout caddy.Duration `json:"timeout,omitempty"`
 
-	// Allow specifies which IPs are allowed to use this wrapper
+	// Allow specifies which IPs or CIDR blocks are allowed to use this wrapper
 	Allow []string `json:"allow,omitempty"`
 
-	// Deny specifies which IPs are not allowed to use this wrapper
+	// Deny specifies which IPs or CIDR blocks are not allowed to use this wrapper
 	Deny []string `json:"deny,omitempty"`
 
+	// TrustedProxies lists the CIDR blocks that may front this listener
+	// over the PROXY protocol. A connection whose peer address falls in
+	// one of these blocks has a PROXY protocol v1/v2 header parsed off
+	// the raw connection, and the client address it carries - rather than
+	// the peer address - is used for Allow/Deny evaluation.
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+
+	// Routes, if non-empty, enables SNI-based proxying: once the STARTTLS
+	// handshake completes, the client's ClientHello SNI is matched
+	// against each route in order and, on a match, the connection is
+	// proxied to that route's upstream instead of being handed to
+	// Caddy's own TLS termination.
+	Routes []Route `json:"routes,omitempty"`
+
+	// GSSAPI controls how PostgreSQL GSSENCRequest messages are handled:
+	// "allow" replies 'N' so the client falls back to plaintext or SSL,
+	// "reject" closes the connection outright, and "passthrough" leaves
+	// the raw bytes untouched for a downstream handler. Defaults to
+	// "passthrough" if unset.
+	GSSAPI string `json:"gssapi,omitempty"`
+
+	// Audit configures structured logging of Postgres connection lifecycle
+	// events. Auditing is disabled if Audit is the zero value.
+	Audit AuditConfig `json:"audit,omitempty"`
+
+	// IdentityResolver, if set, enriches audit log records with an
+	// identity (e.g. a Tailscale user or machine name) for each peer. It
+	// is a Go-level extension point only; it cannot be set from a
+	// Caddyfile or JSON config.
+	IdentityResolver IdentityResolver `json:"-"`
+
 	Logger *zap.Logger
+
+	allowPrefixes   []netip.Prefix
+	denyPrefixes    []netip.Prefix
+	trustedPrefixes []netip.Prefix
+	auditor         *auditor
 }
 
 // Provision sets up the listener wrapper.
@@ -45,19 +109,49 @@ func (pp *ListenerWrapper) Provision(ctx caddy.Context) error {
 		pp.Timeout = caddy.Duration(300 * time.Millisecond)
 	}
 
+	if pp.GSSAPI == "" {
+		pp.GSSAPI = GSSAPIPassthrough
+	}
+	switch pp.GSSAPI {
+	case GSSAPIAllow, GSSAPIReject, GSSAPIPassthrough:
+	default:
+		return fmt.Errorf("invalid gssapi value %q: must be %q, %q, or %q", pp.GSSAPI, GSSAPIAllow, GSSAPIReject, GSSAPIPassthrough)
+	}
+
+	var err error
+	if pp.allowPrefixes, err = parsePrefixList(pp.Allow); err != nil {
+		return fmt.Errorf("parsing allow list: %w", err)
+	}
+	if pp.denyPrefixes, err = parsePrefixList(pp.Deny); err != nil {
+		return fmt.Errorf("parsing deny list: %w", err)
+	}
+	if pp.trustedPrefixes, err = parsePrefixList(pp.TrustedProxies); err != nil {
+		return fmt.Errorf("parsing trusted_proxies: %w", err)
+	}
+
 	pp.Logger = ctx.Logger(pp)
 
+	auditor, err := newAuditor(pp.Logger, pp.Audit, pp.IdentityResolver)
+	if err != nil {
+		return fmt.Errorf("provisioning audit log: %w", err)
+	}
+	pp.auditor = auditor
+
 	return nil
 }
 
 // WrapListener adds PostgreSQL SSL support to the listener.
 func (pp *ListenerWrapper) WrapListener(l net.Listener) net.Listener {
 	return &pgListener{
-		Listener: l,
-		timeout:  time.Duration(pp.Timeout),
-		allow:    pp.Allow,
-		deny:     pp.Deny,
-		logger:   pp.Logger,
+		Listener:       l,
+		timeout:        time.Duration(pp.Timeout),
+		allow:          pp.allowPrefixes,
+		deny:           pp.denyPrefixes,
+		trustedProxies: pp.trustedPrefixes,
+		routes:         pp.Routes,
+		gssapi:         pp.GSSAPI,
+		auditor:        pp.auditor,
+		logger:         pp.Logger,
 	}
 }
 
@@ -65,105 +159,320 @@ func (pp *ListenerWrapper) WrapListener(l net.Listener) net.Listener {
 // and handles the initial SSL handshake.
 type pgListener struct {
 	net.Listener
-	timeout time.Duration
-	allow   []string
-	deny    []string
-	logger  *zap.Logger
+	timeout        time.Duration
+	allow          []netip.Prefix
+	deny           []netip.Prefix
+	trustedProxies []netip.Prefix
+	routes         []Route
+	gssapi         string
+	auditor        *auditor
+	logger         *zap.Logger
 }
 
-// Accept accepts and returns the next connection to the listener.
+// Accept accepts and returns the next connection to the listener. Connections
+// that are proxied away to a routed upstream are handled internally and are
+// not returned; Accept keeps looping until it has a connection to hand back.
 func (l *pgListener) Accept() (net.Conn, error) {
-	conn, err := l.Listener.Accept()
-	if err != nil {
-		l.logger.Error("Error accepting connection", zap.Error(err))
-		return nil, err
-	}
-
-	// Check if the IP is in the deny list - return the original connection if denied
-	// This allows other components to process the connection instead of rejecting
-	if len(l.deny) > 0 {
-		ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
-		for _, deny := range l.deny {
-			if ip == deny {
-				l.logger.Debug("denied connection by deny list", zap.String("ip", ip))
-				return conn, nil // Return original connection instead of closing
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			l.logger.Error("Error accepting connection", zap.Error(err))
+			return nil, err
+		}
+		acceptedAt := time.Now()
+
+		if l.auditor != nil {
+			l.auditor.accepted(conn)
+		}
+
+		// Set a timeout for peeking data
+		if l.timeout > 0 {
+			err = conn.SetReadDeadline(time.Now().Add(l.timeout))
+			if err != nil {
+				// On error setting deadline, return the original connection
+				// to allow other wrappers to handle it potentially.
+				l.logger.Error("Error setting read deadline", zap.Error(err))
+				return conn, nil
 			}
 		}
-	}
 
-	// Check if the IP is in the allow list (if specified)
-	// If allow list exists and IP is not in it, return original connection
-	if len(l.allow) > 0 {
-		ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
-		allowed := false
-		for _, allow := range l.allow {
-			if ip == allow {
-				l.logger.Debug("allowed connection by allow list", zap.String("ip", ip))
-				allowed = true
-				break
+		// Create a buffered reader to peek the header
+		// Use a large enough buffer size to optimize memory usage
+		br := bufio.NewReaderSize(conn, 4096)
+
+		// Resolve the address to evaluate against allow/deny: normally the
+		// peer address, but if it's a trusted proxy, the real client
+		// address carried in a PROXY protocol header.
+		clientAddr := peerAddr(conn)
+		if len(l.trustedProxies) > 0 && containsAddr(l.trustedProxies, clientAddr) {
+			real, err := readProxyHeader(br)
+			switch {
+			case err == nil:
+				clientAddr = real
+			case errors.Is(err, errNotProxyHeader), errors.Is(err, errProxyUnknown):
+				// No header, or a health-check connection with no real
+				// client address: keep using the peer address.
+			default:
+				l.logger.Error("Error reading PROXY protocol header", zap.Error(err))
 			}
 		}
-		if !allowed {
+
+		// Check if the client is in the deny list - return the original connection if denied
+		// This allows other components to process the connection instead of rejecting
+		if len(l.deny) > 0 && containsAddr(l.deny, clientAddr) {
+			l.logger.Debug("denied connection by deny list", zap.String("ip", clientAddr.String()))
+			if l.auditor != nil {
+				l.auditor.rejected(conn, "denied")
+			}
+			if l.timeout > 0 {
+				_ = conn.SetReadDeadline(time.Time{})
+			}
+			if br.Buffered() > 0 {
+				// Bytes already read off conn (e.g. while looking for a
+				// PROXY header) are still in br; hand them up with it.
+				return &pgConn{Conn: conn, reader: br}, nil
+			}
 			return conn, nil // Return original connection instead of closing
 		}
-	}
 
-	// Set a timeout for peeking data
-	if l.timeout > 0 {
-		err = conn.SetReadDeadline(time.Now().Add(l.timeout))
-		if err != nil {
-			// On error setting deadline, return the original connection
-			// to allow other wrappers to handle it potentially.
-			l.logger.Error("Error setting read deadline", zap.Error(err))
-			return conn, nil
+		// Check if the client is in the allow list (if specified)
+		// If allow list exists and the client is not in it, return original connection
+		if len(l.allow) > 0 && !containsAddr(l.allow, clientAddr) {
+			if l.auditor != nil {
+				l.auditor.rejected(conn, "not allowed")
+			}
+			if l.timeout > 0 {
+				_ = conn.SetReadDeadline(time.Time{})
+			}
+			if br.Buffered() > 0 {
+				return &pgConn{Conn: conn, reader: br}, nil
+			}
+			return conn, nil // Return original connection instead of closing
 		}
-	}
 
-	// Create a buffered reader to peek the header
-	// Use a large enough buffer size to optimize memory usage
-	br := bufio.NewReaderSize(conn, 4096)
+		// libpq built with GSSAPI support sends a GSSENCRequest ahead of the
+		// SSL request; handle it per the configured policy before looking
+		// for the SSL request itself.
+		isGSS, gssErr := isGSSENCRequest(br)
+		if gssErr != nil {
+			l.logger.Error("Error detecting PostgreSQL GSSENCRequest", zap.Error(gssErr))
+		}
+		if isGSS && gssErr == nil {
+			l.logger.Debug("Detected PostgreSQL GSSENCRequest", zap.String("gssapi", l.gssapi))
+			switch l.gssapi {
+			case GSSAPIReject:
+				if l.auditor != nil {
+					l.auditor.rejected(conn, "gssapi reject")
+				}
+				_ = conn.Close()
+				continue
+			case GSSAPIAllow:
+				if _, err := br.Discard(len(PostgresGSSENCMsg)); err != nil {
+					l.logger.Error("Error discarding GSSENCRequest", zap.Error(err))
+					return conn, nil
+				}
+				if _, err := conn.Write(PostgresGSSENCReply); err != nil {
+					l.logger.Error("Error replying to GSSENCRequest", zap.Error(err))
+					return conn, nil
+				}
+				// Fall through: the client now proceeds with a plaintext
+				// message or an SSL request, handled by the detection below.
+			case GSSAPIPassthrough:
+				// Leave the bytes in br untouched; isPostgres below won't
+				// match them, so they're handed up as-is.
+			}
+		}
 
-	// Try to detect if this is a PostgreSQL SSL request
-	isPg, peekErr := isPostgres(br)
-	if peekErr != nil {
-		l.logger.Error("Error detecting PostgreSQL SSL request", zap.Error(peekErr))
+		// Try to detect if this is a PostgreSQL SSL request
+		isPg, peekErr := isPostgres(br)
+		if peekErr != nil {
+			l.logger.Error("Error detecting PostgreSQL SSL request", zap.Error(peekErr))
+		}
+
+		l.logger.Debug("Detected PostgreSQL SSL request", zap.Bool("isPg", isPg), zap.Error(err))
+
+		// Reset the deadline immediately after peeking
+		if l.timeout > 0 {
+			_ = conn.SetReadDeadline(time.Time{})
+		}
+
+		msgSent := false
+		sni := ""
+		if isPg && peekErr == nil && len(l.routes) > 0 {
+			var outcome routeOutcome
+			var err error
+			outcome, err = l.routeConnection(conn, br, acceptedAt)
+			if err != nil {
+				l.logger.Error("Error routing PostgreSQL connection", zap.Error(err))
+			}
+			br = outcome.reader
+			sni = outcome.sni
+			// Only the reply actually being written desyncs the STARTTLS
+			// handshake state from Postgres's point of view; routing
+			// itself (matching and dialing an upstream) can still fail
+			// afterwards without affecting that.
+			msgSent = outcome.handshakeDone
+			if outcome.routed {
+				// Handled internally (proxied to an upstream); keep
+				// accepting rather than returning this connection.
+				continue
+			}
+		}
+
+		if l.auditor != nil && !msgSent {
+			// routeConnection, when it ran, already emitted the startTLS
+			// audit event itself - it's the one that actually negotiates
+			// STARTTLS, and it peeked the SNI to do so. Otherwise,
+			// STARTTLS (and with it the client's ClientHello) is still
+			// deferred to pgConn's first Read, so there's no SNI to peek
+			// yet without blocking this accept loop on a reply the
+			// client hasn't received: just record whether it negotiated.
+			l.auditor.startTLS(conn, isPg && peekErr == nil, sni)
+		}
+
+		// Regardless of whether it's PG or if there was a peek error,
+		// wrap the connection with pgConn to ensure the buffered reader is used.
+		// The isTLS flag will determine the behavior in pgConn.Read.
+		// If peekErr is not nil (e.g., timeout), isPg will be false.
+		return &pgConn{
+			Conn:    conn,
+			reader:  br,
+			isPgTLS: isPg && peekErr == nil, // Only treat as TLS if detection succeeded
+			msgSent: msgSent,
+			auditor: l.auditor,
+			start:   acceptedAt,
+		}, nil
 	}
+}
+
+// routeOutcome reports the result of an attempt to route a connection.
+type routeOutcome struct {
+	// routed is true if the connection was handed off to a route's
+	// upstream and should not be processed any further by this listener.
+	routed bool
+
+	// sni is the SNI seen in the client's ClientHello, if any.
+	sni string
+
+	// handshakeDone is true once the STARTTLS reply has actually been
+	// written to the client, independent of whether routing itself (SNI
+	// matching, dialing the upstream) went on to succeed.
+	handshakeDone bool
+
+	// reader is br, or a larger *bufio.Reader wrapping it if peeking the
+	// ClientHello required growing the buffer. Callers must use this in
+	// place of the br they passed in for anything read afterwards.
+	reader *bufio.Reader
+}
 
-	l.logger.Debug("Detected PostgreSQL SSL request", zap.Bool("isPg", isPg), zap.Error(err))
+// routeConnection completes the STARTTLS handshake and, if the client's
+// ClientHello SNI matches one of l.routes, proxies the connection to that
+// route's upstream, emitting the same audit events for it that pgConn
+// emits for non-routed connections.
+func (l *pgListener) routeConnection(conn net.Conn, br *bufio.Reader, acceptedAt time.Time) (routeOutcome, error) {
+	out := routeOutcome{reader: br}
+
+	if _, err := br.Discard(len(PostgresStartTLSMsg)); err != nil {
+		return out, err
+	}
+	if _, err := conn.Write(PostgresStartTLSReply); err != nil {
+		return out, err
+	}
+	out.handshakeDone = true
 
-	// Reset the deadline immediately after peeking
+	if l.timeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(l.timeout))
+	}
+	sni, reader, err := peekClientHelloSNI(br)
 	if l.timeout > 0 {
 		_ = conn.SetReadDeadline(time.Time{})
 	}
+	out.sni = sni
+	out.reader = reader
+	if l.auditor != nil {
+		// STARTTLS was genuinely negotiated here, regardless of whether a
+		// route goes on to match; this is the only audit event a routed
+		// connection gets until it closes.
+		l.auditor.startTLS(conn, true, sni)
+	}
+	if err != nil {
+		return out, err
+	}
+	if sni == "" {
+		return out, nil
+	}
+
+	route, ok := matchRoute(l.routes, sni)
+	if !ok {
+		return out, nil
+	}
 
-	// Regardless of whether it's PG or if there was a peek error,
-	// wrap the connection with pgConn to ensure the buffered reader is used.
-	// The isTLS flag will determine the behavior in pgConn.Read.
-	// If peekErr is not nil (e.g., timeout), isPg will be false.
-	return &pgConn{
-		Conn:    conn,
-		reader:  br,
-		isPgTLS: isPg && peekErr == nil, // Only treat as TLS if detection succeeded
-	}, nil
+	upstream, err := net.DialTimeout("tcp", route.Upstream, routeDialTimeout)
+	if err != nil {
+		return out, err
+	}
+
+	logSNI := sni
+	if route.TLSSNI != "" {
+		logSNI = route.TLSSNI
+	}
+	l.logger.Debug("routing PostgreSQL connection",
+		zap.String("sni", logSNI),
+		zap.String("upstream", route.Upstream))
+
+	go func() {
+		bytesIn, bytesOut, proxyErr := proxy(conn, upstream, out.reader)
+		if l.auditor != nil {
+			l.auditor.closed(conn, acceptedAt, bytesIn, bytesOut, proxyErr)
+		}
+		if proxyErr != nil {
+			l.logger.Debug("PostgreSQL route proxy closed", zap.Error(proxyErr))
+		}
+	}()
+
+	out.routed = true
+	return out, nil
 }
 
-// isPostgres determines whether the buffer contains the Postgres STARTTLS message.
+// isPostgres determines whether the buffer contains the Postgres STARTTLS
+// message.
 func isPostgres(br *bufio.Reader) (bool, error) {
-	// Peek the exact number of bytes we need for the PostgreSQL SSL request
-	peeked, err := br.Peek(len(PostgresStartTLSMsg))
-	if err != nil {
-		// Don't log EOF or timeout errors, they are expected in some cases
-		// But return the error so Accept knows detection might have failed
-		var opErr *net.OpError
-		if errors.Is(err, io.EOF) || (errors.As(err, &opErr) && opErr.Timeout()) {
+	return peekMessage(br, PostgresStartTLSMsg)
+}
+
+// isGSSENCRequest determines whether the buffer contains a Postgres
+// GSSENCRequest message.
+func isGSSENCRequest(br *bufio.Reader) (bool, error) {
+	return peekMessage(br, PostgresGSSENCMsg)
+}
+
+// peekMessage reports whether br's next bytes match msg. It peeks
+// incrementally, one additional byte at a time, and bails out as soon as
+// the peeked prefix no longer matches msg. This keeps connections that
+// send fewer than len(msg) bytes and then wait for a server-first
+// response - e.g. some SMTP/IMAP banners sharing the port via another
+// listener wrapper - from blocking until the read deadline expires.
+func peekMessage(br *bufio.Reader, msg []byte) (bool, error) {
+	for n := 1; n <= len(msg); n++ {
+		peeked, err := br.Peek(n)
+		if err != nil {
+			// Don't log EOF or timeout errors, they are expected in some cases
+			// But return the error so Accept knows detection might have failed
+			var opErr *net.OpError
+			if errors.Is(err, io.EOF) || (errors.As(err, &opErr) && opErr.Timeout()) {
+				return false, err
+			}
+			// Log other unexpected errors
 			return false, err
 		}
-		// Log other unexpected errors
-		return false, err
+
+		if !bytes.Equal(peeked, msg[:n]) {
+			// Prefix mismatch: no need to wait for the remaining bytes.
+			return false, nil
+		}
 	}
 
-	// Check if the peeked bytes match the PostgreSQL SSL request
-	return bytes.Equal(peeked, PostgresStartTLSMsg), nil
+	return true, nil
 }
 
 // pgConn is a net.Conn that handles PostgreSQL SSL negotiation
@@ -175,6 +484,12 @@ type pgConn struct {
 
 	mu      sync.Mutex
 	msgSent bool // Whether the SSL handshake has been completed
+
+	auditor   *auditor // nil if auditing is disabled
+	start     time.Time
+	bytesIn   int64
+	bytesOut  int64
+	closeOnce sync.Once
 }
 
 // Read reads data from the connection.
@@ -182,6 +497,11 @@ type pgConn struct {
 func (c *pgConn) Read(b []byte) (n int, err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	defer func() {
+		if c.auditor != nil && n > 0 {
+			atomic.AddInt64(&c.bytesIn, int64(n))
+		}
+	}()
 
 	// If it's not a PG TLS connection, just read directly from the buffered reader.
 	if !c.isPgTLS {
@@ -217,5 +537,20 @@ func (c *pgConn) Read(b []byte) (n int, err error) {
 
 // Write writes data to the connection.
 func (c *pgConn) Write(b []byte) (n int, err error) {
-	return c.Conn.Write(b)
+	n, err = c.Conn.Write(b)
+	if c.auditor != nil && n > 0 {
+		atomic.AddInt64(&c.bytesOut, int64(n))
+	}
+	return n, err
+}
+
+// Close closes the connection, emitting a close audit event on the first call.
+func (c *pgConn) Close() error {
+	err := c.Conn.Close()
+	if c.auditor != nil {
+		c.closeOnce.Do(func() {
+			c.auditor.closed(c.Conn, c.start, atomic.LoadInt64(&c.bytesIn), atomic.LoadInt64(&c.bytesOut), err)
+		})
+	}
+	return err
 }
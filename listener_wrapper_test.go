@@ -0,0 +1,84 @@
+package wrapper
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestPeekMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+		in   []byte
+		want bool
+	}{
+		{name: "exact match", msg: PostgresStartTLSMsg, in: PostgresStartTLSMsg, want: true},
+		{
+			name: "match with trailing data",
+			msg:  PostgresStartTLSMsg,
+			in:   append(append([]byte{}, PostgresStartTLSMsg...), []byte("extra")...),
+			want: true,
+		},
+		{name: "mismatch on first byte", msg: PostgresStartTLSMsg, in: []byte("PROXY TCP4 ...\r\n"), want: false},
+		{
+			name: "mismatch partway through",
+			msg:  PostgresStartTLSMsg,
+			in:   []byte{0, 0, 0, 8, 4, 210, 22, 48}, // GSSENCRequest, differs in the last byte
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			br := bufio.NewReader(bytes.NewReader(tt.in))
+			got, err := peekMessage(br, tt.msg)
+			if err != nil {
+				t.Fatalf("peekMessage: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("peekMessage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPeekMessage_ShortRead(t *testing.T) {
+	// Fewer bytes than msg arrive and then the connection is at EOF (e.g.
+	// another protocol's short banner): peekMessage should report the
+	// read error rather than blocking or panicking.
+	br := bufio.NewReader(bytes.NewReader(PostgresStartTLSMsg[:3]))
+	got, err := peekMessage(br, PostgresStartTLSMsg)
+	if got {
+		t.Error("peekMessage() = true for a truncated message")
+	}
+	if err == nil {
+		t.Error("expected an error for a truncated message")
+	}
+}
+
+func TestIsPostgresAndIsGSSENCRequest(t *testing.T) {
+	t.Run("isPostgres matches STARTTLS", func(t *testing.T) {
+		br := bufio.NewReader(bytes.NewReader(PostgresStartTLSMsg))
+		ok, err := isPostgres(br)
+		if err != nil || !ok {
+			t.Fatalf("isPostgres() = %v, %v; want true, nil", ok, err)
+		}
+	})
+
+	t.Run("isGSSENCRequest matches GSSENCRequest", func(t *testing.T) {
+		br := bufio.NewReader(bytes.NewReader(PostgresGSSENCMsg))
+		ok, err := isGSSENCRequest(br)
+		if err != nil || !ok {
+			t.Fatalf("isGSSENCRequest() = %v, %v; want true, nil", ok, err)
+		}
+	})
+
+	t.Run("isGSSENCRequest doesn't match a plain STARTTLS message", func(t *testing.T) {
+		br := bufio.NewReader(bytes.NewReader(PostgresStartTLSMsg))
+		ok, err := isGSSENCRequest(br)
+		if err != nil || ok {
+			t.Fatalf("isGSSENCRequest() = %v, %v; want false, nil", ok, err)
+		}
+	})
+}
@@ -20,8 +20,19 @@ func (ListenerWrapper) CaddyModule() caddy.ModuleInfo {
 //
 //	postgres_ssl {
 //		timeout <duration>
-//		allow <IPs...>
-//		deny <IPs...>
+//		allow <IPs-or-CIDRs...>
+//		deny <IPs-or-CIDRs...>
+//		trusted_proxies <CIDRs...>
+//		route <sni-pattern> {
+//			upstream <host:port>
+//			tls_sni <name>
+//		}
+//		gssapi allow|reject|passthrough
+//		audit {
+//			file <path>
+//			logger <name>
+//			identity_field <name>
+//		}
 //	}
 func (w *ListenerWrapper) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	d.Next() // consume wrapper name
@@ -47,6 +58,75 @@ func (w *ListenerWrapper) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 			w.Allow = append(w.Allow, d.RemainingArgs()...)
 		case "deny":
 			w.Deny = append(w.Deny, d.RemainingArgs()...)
+		case "trusted_proxies":
+			w.TrustedProxies = append(w.TrustedProxies, d.RemainingArgs()...)
+
+		case "route":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			route := Route{Pattern: d.Val()}
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				switch d.Val() {
+				case "upstream":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					route.Upstream = d.Val()
+				case "tls_sni":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					route.TLSSNI = d.Val()
+				default:
+					return d.ArgErr()
+				}
+			}
+			if route.Upstream == "" {
+				return d.Errf("route %q: missing upstream", route.Pattern)
+			}
+			w.Routes = append(w.Routes, route)
+
+		case "gssapi":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			switch d.Val() {
+			case GSSAPIAllow, GSSAPIReject, GSSAPIPassthrough:
+				w.GSSAPI = d.Val()
+			default:
+				return d.Errf("invalid gssapi value %q", d.Val())
+			}
+
+		case "audit":
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				switch d.Val() {
+				case "file":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					w.Audit.File = d.Val()
+				case "logger":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					w.Audit.Logger = d.Val()
+				case "identity_field":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					w.Audit.IdentityField = d.Val()
+				default:
+					return d.ArgErr()
+				}
+			}
+			if w.Audit.File != "" && w.Audit.Logger != "" {
+				return d.Err("audit: file and logger are mutually exclusive")
+			}
+
 		default:
 			return d.ArgErr()
 		}
@@ -54,9 +134,21 @@ func (w *ListenerWrapper) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	return nil
 }
 
+// Cleanup closes resources opened by Provision, such as the audit log
+// file. Caddy calls this on every config unload, including the old
+// config's module instances after a reload, so it must run even though
+// Provision runs again for the new config.
+func (pp *ListenerWrapper) Cleanup() error {
+	if pp.auditor == nil {
+		return nil
+	}
+	return pp.auditor.Cleanup()
+}
+
 // Interface guards
 var (
 	_ caddy.Provisioner     = (*ListenerWrapper)(nil)
+	_ caddy.CleanerUpper    = (*ListenerWrapper)(nil)
 	_ caddy.Module          = (*ListenerWrapper)(nil)
 	_ caddy.ListenerWrapper = (*ListenerWrapper)(nil)
 	_ caddyfile.Unmarshaler = (*ListenerWrapper)(nil)
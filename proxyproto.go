@@ -0,0 +1,107 @@
+package wrapper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// errNotProxyHeader indicates the connection's next bytes don't start with
+// a PROXY protocol v1 or v2 signature; no bytes were consumed.
+var errNotProxyHeader = errors.New("not a PROXY protocol header")
+
+// errProxyUnknown indicates a well-formed PROXY protocol header that
+// carries no usable client address (a v1 "UNKNOWN" or v2 LOCAL command,
+// both used for health checks rather than proxied connections).
+var errProxyUnknown = errors.New("PROXY protocol header carries no client address")
+
+var proxyProtoV2Sig = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// readProxyHeader reads a PROXY protocol v1 or v2 header off br and returns
+// the client address it carries. The header bytes are consumed from br;
+// everything after them is left untouched for the caller to read normally.
+func readProxyHeader(br *bufio.Reader) (netip.Addr, error) {
+	if sig, err := br.Peek(len(proxyProtoV2Sig)); err == nil && bytes.Equal(sig, proxyProtoV2Sig) {
+		return readProxyHeaderV2(br)
+	}
+	if prefix, err := br.Peek(6); err == nil && bytes.Equal(prefix, []byte("PROXY ")) {
+		return readProxyHeaderV1(br)
+	}
+	return netip.Addr{}, errNotProxyHeader
+}
+
+// readProxyHeaderV1 reads a text PROXY protocol v1 header, e.g.:
+//
+//	PROXY TCP4 192.0.2.1 198.51.100.1 56324 5432\r\n
+func readProxyHeaderV1(br *bufio.Reader) (netip.Addr, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	fields := strings.Fields(strings.TrimSpace(line))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return netip.Addr{}, fmt.Errorf("malformed PROXY v1 header: %q", line)
+	}
+	if fields[1] == "UNKNOWN" {
+		return netip.Addr{}, errProxyUnknown
+	}
+	if len(fields) < 3 {
+		return netip.Addr{}, fmt.Errorf("malformed PROXY v1 header: %q", line)
+	}
+
+	addr, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("parsing PROXY v1 source address: %w", err)
+	}
+	return addr, nil
+}
+
+// readProxyHeaderV2 reads a binary PROXY protocol v2 header: a 12-byte
+// signature, a version/command byte, an address-family/protocol byte, a
+// 2-byte address block length, and the address block itself.
+func readProxyHeaderV2(br *bufio.Reader) (netip.Addr, error) {
+	hdr, err := br.Peek(16)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	verCmd := hdr[12]
+	if verCmd>>4 != 0x2 {
+		return netip.Addr{}, fmt.Errorf("unsupported PROXY protocol version %d", verCmd>>4)
+	}
+	cmd := verCmd & 0x0F
+	family := hdr[13] >> 4
+	addrLen := int(binary.BigEndian.Uint16(hdr[14:16]))
+
+	full, err := br.Peek(16 + addrLen)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if _, err := br.Discard(len(full)); err != nil {
+		return netip.Addr{}, err
+	}
+
+	if cmd == 0x0 { // LOCAL: health check, carries no real client address
+		return netip.Addr{}, errProxyUnknown
+	}
+
+	addrBlock := full[16:]
+	switch family {
+	case 0x1: // AF_INET
+		if len(addrBlock) < 4 {
+			return netip.Addr{}, errors.New("truncated PROXY v2 IPv4 address")
+		}
+		return netip.AddrFrom4([4]byte(addrBlock[:4])), nil
+	case 0x2: // AF_INET6
+		if len(addrBlock) < 16 {
+			return netip.Addr{}, errors.New("truncated PROXY v2 IPv6 address")
+		}
+		return netip.AddrFrom16([16]byte(addrBlock[:16])), nil
+	default:
+		return netip.Addr{}, fmt.Errorf("unsupported PROXY v2 address family %d", family)
+	}
+}
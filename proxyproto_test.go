@@ -0,0 +1,189 @@
+package wrapper
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestReadProxyHeaderV1(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    netip.Addr
+		wantErr error // checked with errors.Is if set
+	}{
+		{
+			name: "TCP4",
+			line: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 5432\r\n",
+			want: netip.MustParseAddr("192.0.2.1"),
+		},
+		{
+			name: "TCP6",
+			line: "PROXY TCP6 2001:db8::1 2001:db8::2 56324 5432\r\n",
+			want: netip.MustParseAddr("2001:db8::1"),
+		},
+		{
+			name:    "UNKNOWN carries no address",
+			line:    "PROXY UNKNOWN\r\n",
+			wantErr: errProxyUnknown,
+		},
+		{
+			name:    "malformed",
+			line:    "PROXY\r\n",
+			wantErr: nil, // just checking it's a non-nil error below
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			br := bufio.NewReader(bytes.NewReader([]byte(tt.line)))
+			got, err := readProxyHeaderV1(br)
+			if tt.name == "malformed" {
+				if err == nil {
+					t.Fatal("expected an error for a malformed header")
+				}
+				return
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readProxyHeaderV1: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("addr = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// buildProxyV2 assembles a binary PROXY protocol v2 header for an IPv4 or
+// IPv6 source/destination pair.
+func buildProxyV2(t *testing.T, cmd byte, family byte, src, dst netip.Addr, srcPort, dstPort uint16) []byte {
+	t.Helper()
+
+	var addrBlock []byte
+	switch family {
+	case 0x1:
+		s, d := src.As4(), dst.As4()
+		addrBlock = append(addrBlock, s[:]...)
+		addrBlock = append(addrBlock, d[:]...)
+	case 0x2:
+		s, d := src.As16(), dst.As16()
+		addrBlock = append(addrBlock, s[:]...)
+		addrBlock = append(addrBlock, d[:]...)
+	}
+	addrBlock = append(addrBlock, byte(srcPort>>8), byte(srcPort))
+	addrBlock = append(addrBlock, byte(dstPort>>8), byte(dstPort))
+
+	hdr := append([]byte{}, proxyProtoV2Sig...)
+	hdr = append(hdr, 0x20|cmd, family<<4|0x1) // version 2, TCP
+	hdr = append(hdr, byte(len(addrBlock)>>8), byte(len(addrBlock)))
+	hdr = append(hdr, addrBlock...)
+	return hdr
+}
+
+func TestReadProxyHeaderV2(t *testing.T) {
+	t.Run("IPv4 proxied connection", func(t *testing.T) {
+		src := netip.MustParseAddr("192.0.2.1")
+		dst := netip.MustParseAddr("198.51.100.1")
+		hdr := buildProxyV2(t, 0x1, 0x1, src, dst, 56324, 5432)
+		br := bufio.NewReader(bytes.NewReader(hdr))
+
+		got, err := readProxyHeaderV2(br)
+		if err != nil {
+			t.Fatalf("readProxyHeaderV2: %v", err)
+		}
+		if got != src {
+			t.Errorf("addr = %v, want %v", got, src)
+		}
+	})
+
+	t.Run("IPv6 proxied connection", func(t *testing.T) {
+		src := netip.MustParseAddr("2001:db8::1")
+		dst := netip.MustParseAddr("2001:db8::2")
+		hdr := buildProxyV2(t, 0x1, 0x2, src, dst, 56324, 5432)
+		br := bufio.NewReader(bytes.NewReader(hdr))
+
+		got, err := readProxyHeaderV2(br)
+		if err != nil {
+			t.Fatalf("readProxyHeaderV2: %v", err)
+		}
+		if got != src {
+			t.Errorf("addr = %v, want %v", got, src)
+		}
+	})
+
+	t.Run("LOCAL command is a health check with no address", func(t *testing.T) {
+		src := netip.MustParseAddr("192.0.2.1")
+		dst := netip.MustParseAddr("198.51.100.1")
+		hdr := buildProxyV2(t, 0x0, 0x1, src, dst, 0, 0)
+		br := bufio.NewReader(bytes.NewReader(hdr))
+
+		_, err := readProxyHeaderV2(br)
+		if !errors.Is(err, errProxyUnknown) {
+			t.Fatalf("err = %v, want errProxyUnknown", err)
+		}
+	})
+
+	t.Run("bytes after the header are left for the caller", func(t *testing.T) {
+		src := netip.MustParseAddr("192.0.2.1")
+		dst := netip.MustParseAddr("198.51.100.1")
+		hdr := buildProxyV2(t, 0x1, 0x1, src, dst, 56324, 5432)
+		payload := append(append([]byte{}, hdr...), []byte("SSLRequest follows")...)
+		br := bufio.NewReader(bytes.NewReader(payload))
+
+		if _, err := readProxyHeaderV2(br); err != nil {
+			t.Fatalf("readProxyHeaderV2: %v", err)
+		}
+		rest, err := br.Peek(len("SSLRequest follows"))
+		if err != nil {
+			t.Fatalf("Peek remaining bytes: %v", err)
+		}
+		if string(rest) != "SSLRequest follows" {
+			t.Errorf("remaining bytes = %q, want %q", rest, "SSLRequest follows")
+		}
+	})
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	t.Run("dispatches to v1", func(t *testing.T) {
+		br := bufio.NewReader(bytes.NewReader([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 5432\r\n")))
+		got, err := readProxyHeader(br)
+		if err != nil {
+			t.Fatalf("readProxyHeader: %v", err)
+		}
+		if want := netip.MustParseAddr("192.0.2.1"); got != want {
+			t.Errorf("addr = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("dispatches to v2", func(t *testing.T) {
+		src := netip.MustParseAddr("192.0.2.1")
+		dst := netip.MustParseAddr("198.51.100.1")
+		hdr := buildProxyV2(t, 0x1, 0x1, src, dst, 56324, 5432)
+		br := bufio.NewReader(bytes.NewReader(hdr))
+
+		got, err := readProxyHeader(br)
+		if err != nil {
+			t.Fatalf("readProxyHeader: %v", err)
+		}
+		if got != src {
+			t.Errorf("addr = %v, want %v", got, src)
+		}
+	})
+
+	t.Run("not a PROXY header", func(t *testing.T) {
+		br := bufio.NewReader(bytes.NewReader(PostgresStartTLSMsg))
+		_, err := readProxyHeader(br)
+		if !errors.Is(err, errNotProxyHeader) {
+			t.Fatalf("err = %v, want errNotProxyHeader", err)
+		}
+	})
+}
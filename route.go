@@ -0,0 +1,88 @@
+package wrapper
+
+import (
+	"io"
+	"net"
+	"path"
+)
+
+// Route describes a single Postgres routing rule: a connection whose TLS
+// ClientHello SNI matches Pattern is proxied to Upstream instead of being
+// handed up to Caddy's own TLS termination.
+type Route struct {
+	// Pattern is matched against the client's SNI host name. It supports
+	// the same wildcards as path.Match (e.g. "tenant-*.db.example.com").
+	Pattern string `json:"pattern,omitempty"`
+
+	// Upstream is the backend address (host:port) that matched
+	// connections are proxied to.
+	Upstream string `json:"upstream,omitempty"`
+
+	// TLSSNI, if set, is the SNI name shown in the debug log of the
+	// routing decision in place of the name the client actually sent.
+	// Audit events still record the client's own SNI. The TLS bytes
+	// themselves are always forwarded to Upstream unmodified, since this
+	// wrapper never terminates the Postgres TLS connection itself.
+	TLSSNI string `json:"tls_sni,omitempty"`
+}
+
+// match reports whether sni satisfies the route's pattern.
+func (r Route) match(sni string) bool {
+	if r.Pattern == "" {
+		return false
+	}
+	ok, err := path.Match(r.Pattern, sni)
+	return err == nil && ok
+}
+
+// matchRoute returns the first route in routes whose pattern matches sni.
+func matchRoute(routes []Route, sni string) (Route, bool) {
+	for _, r := range routes {
+		if r.match(sni) {
+			return r, true
+		}
+	}
+	return Route{}, false
+}
+
+// proxy pipes data bidirectionally between client and upstream until
+// either side closes or errors, then closes both. clientReader is used in
+// place of client for the client->upstream direction so that any bytes
+// already buffered (e.g. the peeked ClientHello) are forwarded first. It
+// returns the number of bytes copied in each direction, so callers can
+// include them in their own audit/metrics records.
+func proxy(client net.Conn, upstream net.Conn, clientReader io.Reader) (bytesIn, bytesOut int64, err error) {
+	type copyResult struct {
+		n   int64
+		err error
+	}
+	inc := make(chan copyResult, 1)
+	outc := make(chan copyResult, 1)
+
+	go func() {
+		n, err := io.Copy(upstream, clientReader)
+		inc <- copyResult{n, err}
+	}()
+	go func() {
+		n, err := io.Copy(client, upstream)
+		outc <- copyResult{n, err}
+	}()
+
+	var in, out copyResult
+	select {
+	case in = <-inc:
+		_ = client.Close()
+		_ = upstream.Close()
+		out = <-outc
+	case out = <-outc:
+		_ = client.Close()
+		_ = upstream.Close()
+		in = <-inc
+	}
+
+	err = in.err
+	if err == nil {
+		err = out.err
+	}
+	return in.n, out.n, err
+}
@@ -0,0 +1,141 @@
+package wrapper
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRouteMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		sni     string
+		want    bool
+	}{
+		{name: "exact match", pattern: "db.example.com", sni: "db.example.com", want: true},
+		{name: "wildcard match", pattern: "tenant-*.db.example.com", sni: "tenant-a.db.example.com", want: true},
+		{name: "wildcard no match", pattern: "tenant-*.db.example.com", sni: "other.db.example.com", want: false},
+		{name: "empty pattern never matches", pattern: "", sni: "db.example.com", want: false},
+		{name: "empty sni", pattern: "tenant-*.db.example.com", sni: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Route{Pattern: tt.pattern}
+			if got := r.match(tt.sni); got != tt.want {
+				t.Errorf("match(%q) with pattern %q = %v, want %v", tt.sni, tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchRoute(t *testing.T) {
+	routes := []Route{
+		{Pattern: "tenant-a.db.example.com", Upstream: "10.0.0.1:5432"},
+		{Pattern: "tenant-*.db.example.com", Upstream: "10.0.0.2:5432"},
+	}
+
+	t.Run("first match wins", func(t *testing.T) {
+		got, ok := matchRoute(routes, "tenant-a.db.example.com")
+		if !ok {
+			t.Fatal("expected a match")
+		}
+		if got.Upstream != "10.0.0.1:5432" {
+			t.Errorf("upstream = %q, want the first matching route's upstream", got.Upstream)
+		}
+	})
+
+	t.Run("falls through to a later route", func(t *testing.T) {
+		got, ok := matchRoute(routes, "tenant-b.db.example.com")
+		if !ok {
+			t.Fatal("expected a match")
+		}
+		if got.Upstream != "10.0.0.2:5432" {
+			t.Errorf("upstream = %q, want the second route's upstream", got.Upstream)
+		}
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		_, ok := matchRoute(routes, "unrelated.example.com")
+		if ok {
+			t.Fatal("expected no match")
+		}
+	})
+
+	t.Run("empty routes", func(t *testing.T) {
+		_, ok := matchRoute(nil, "db.example.com")
+		if ok {
+			t.Fatal("expected no match against an empty route list")
+		}
+	})
+}
+
+func TestProxy(t *testing.T) {
+	t.Run("forwards client bytes upstream and reports counts", func(t *testing.T) {
+		client, clientRemote := net.Pipe()
+		upstream, upstreamRemote := net.Pipe()
+
+		done := make(chan struct{})
+		var bytesIn, bytesOut int64
+		var proxyErr error
+		go func() {
+			bytesIn, bytesOut, proxyErr = proxy(client, upstream, client)
+			close(done)
+		}()
+
+		go func() {
+			_, _ = clientRemote.Write([]byte("hello upstream"))
+			_ = clientRemote.Close()
+		}()
+
+		buf := make([]byte, len("hello upstream"))
+		if _, err := io.ReadFull(upstreamRemote, buf); err != nil {
+			t.Fatalf("reading forwarded client bytes: %v", err)
+		}
+		if !bytes.Equal(buf, []byte("hello upstream")) {
+			t.Errorf("upstream got %q, want %q", buf, "hello upstream")
+		}
+
+		_ = upstreamRemote.Close()
+
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("proxy did not return after both sides closed")
+		}
+
+		if bytesIn != int64(len("hello upstream")) {
+			t.Errorf("bytesIn = %d, want %d", bytesIn, len("hello upstream"))
+		}
+		if bytesOut != 0 {
+			t.Errorf("bytesOut = %d, want 0", bytesOut)
+		}
+		_ = proxyErr // nil or io.ErrClosedPipe depending on which side closed first; either is expected.
+	})
+
+	t.Run("closes both sides as soon as the client side finishes", func(t *testing.T) {
+		client, clientRemote := net.Pipe()
+		upstream, upstreamRemote := net.Pipe()
+
+		done := make(chan struct{})
+		go func() {
+			_, _, _ = proxy(client, upstream, client)
+			close(done)
+		}()
+
+		_ = clientRemote.Close()
+
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("proxy did not return after the client side closed")
+		}
+
+		if _, err := upstreamRemote.Write([]byte("x")); err == nil {
+			t.Error("expected the upstream side to be closed once the client side finished")
+		}
+	})
+}
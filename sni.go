@@ -0,0 +1,132 @@
+package wrapper
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// maxClientHelloPeek is the largest a ClientHello's containing TLS record
+// can possibly be: a 5-byte record header plus the record length field's
+// 16-bit maximum.
+const maxClientHelloPeek = 5 + 65535
+
+// peekClientHelloSNI peeks (without consuming) a single TLS record from br
+// and, if it is a ClientHello, extracts the host name carried in its
+// server_name extension. It returns an empty string and no error if the
+// ClientHello is well-formed but carries no SNI extension.
+//
+// If the record doesn't fit in br's buffer, peekClientHelloSNI grows it -
+// wrapping br in a new, larger *bufio.Reader - up to the TLS protocol
+// maximum and retries. Callers must use the returned reader (which may or
+// may not be br itself) for anything read from the connection afterwards,
+// since it may have buffered bytes that br no longer has access to.
+func peekClientHelloSNI(br *bufio.Reader) (string, *bufio.Reader, error) {
+	sni, err := clientHelloSNI(br)
+	if errors.Is(err, bufio.ErrBufferFull) && br.Size() < maxClientHelloPeek {
+		br = bufio.NewReaderSize(br, maxClientHelloPeek)
+		sni, err = clientHelloSNI(br)
+	}
+	if errors.Is(err, bufio.ErrBufferFull) {
+		err = fmt.Errorf("ClientHello larger than the %d-byte TLS record maximum we're willing to buffer: %w", maxClientHelloPeek, err)
+	}
+	return sni, br, err
+}
+
+// clientHelloSNI does the actual parsing for peekClientHelloSNI, against
+// whatever buffer size br happens to have.
+func clientHelloSNI(br *bufio.Reader) (string, error) {
+	// TLS record header: content type(1) + version(2) + length(2).
+	hdr, err := br.Peek(5)
+	if err != nil {
+		return "", err
+	}
+	if hdr[0] != 0x16 {
+		return "", errors.New("not a TLS handshake record")
+	}
+	recLen := int(binary.BigEndian.Uint16(hdr[3:5]))
+
+	record, err := br.Peek(5 + recLen)
+	if err != nil {
+		return "", err
+	}
+	body := record[5:]
+
+	// Handshake header: msg type(1) + length(3). Type 1 is ClientHello.
+	if len(body) < 4 || body[0] != 0x01 {
+		return "", errors.New("not a ClientHello")
+	}
+	hs := body[4:]
+
+	// client_version(2) + random(32) + session_id length(1)
+	if len(hs) < 35 {
+		return "", errors.New("truncated ClientHello")
+	}
+	pos := 34
+
+	sessIDLen := int(hs[pos])
+	pos += 1 + sessIDLen
+	if pos+2 > len(hs) {
+		return "", errors.New("truncated ClientHello")
+	}
+
+	csLen := int(binary.BigEndian.Uint16(hs[pos : pos+2]))
+	pos += 2 + csLen
+	if pos+1 > len(hs) {
+		return "", errors.New("truncated ClientHello")
+	}
+
+	cmLen := int(hs[pos])
+	pos += 1 + cmLen
+	if pos+2 > len(hs) {
+		// No room left for an extensions block, so there's no SNI.
+		return "", nil
+	}
+
+	extLen := int(binary.BigEndian.Uint16(hs[pos : pos+2]))
+	pos += 2
+	if pos+extLen > len(hs) {
+		return "", errors.New("truncated extensions")
+	}
+
+	ext := hs[pos : pos+extLen]
+	for len(ext) >= 4 {
+		extType := binary.BigEndian.Uint16(ext[0:2])
+		extBodyLen := int(binary.BigEndian.Uint16(ext[2:4]))
+		if len(ext) < 4+extBodyLen {
+			return "", errors.New("truncated extension body")
+		}
+		if extType == 0x0000 { // server_name, RFC 6066 section 3
+			return parseServerNameExtension(ext[4 : 4+extBodyLen])
+		}
+		ext = ext[4+extBodyLen:]
+	}
+	return "", nil
+}
+
+// parseServerNameExtension extracts the host_name entry from the body of a
+// server_name extension.
+func parseServerNameExtension(body []byte) (string, error) {
+	if len(body) < 2 {
+		return "", errors.New("truncated server_name extension")
+	}
+	listLen := int(binary.BigEndian.Uint16(body[0:2]))
+	list := body[2:]
+	if len(list) < listLen {
+		return "", errors.New("truncated server_name list")
+	}
+
+	for len(list) >= 3 {
+		nameType := list[0]
+		nameLen := int(binary.BigEndian.Uint16(list[1:3]))
+		if len(list) < 3+nameLen {
+			return "", errors.New("truncated server name entry")
+		}
+		if nameType == 0x00 { // host_name
+			return string(list[3 : 3+nameLen]), nil
+		}
+		list = list[3+nameLen:]
+	}
+	return "", nil
+}
@@ -0,0 +1,156 @@
+package wrapper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// buildClientHello assembles a minimal but well-formed TLS 1.2-framed
+// ClientHello record carrying a server_name extension for sni (if
+// non-empty) padded out with a RFC 7685 padding extension of padLen zero
+// bytes, so tests can exercise records of an arbitrary size.
+func buildClientHello(t *testing.T, sni string, padLen int) []byte {
+	t.Helper()
+
+	var ext bytes.Buffer
+	if sni != "" {
+		name := []byte(sni)
+		var list bytes.Buffer
+		list.WriteByte(0x00) // host_name
+		_ = binary.Write(&list, binary.BigEndian, uint16(len(name)))
+		list.Write(name)
+
+		ext.Write([]byte{0x00, 0x00}) // extension type: server_name
+		_ = binary.Write(&ext, binary.BigEndian, uint16(2+list.Len()))
+		_ = binary.Write(&ext, binary.BigEndian, uint16(list.Len()))
+		ext.Write(list.Bytes())
+	}
+	if padLen > 0 {
+		ext.Write([]byte{0x00, 0x15}) // extension type: padding
+		_ = binary.Write(&ext, binary.BigEndian, uint16(padLen))
+		ext.Write(make([]byte, padLen))
+	}
+
+	var hs bytes.Buffer
+	hs.Write([]byte{0x03, 0x03})                       // client_version: TLS 1.2
+	hs.Write(make([]byte, 32))                         // random
+	hs.WriteByte(0x00)                                 // session_id: empty
+	_ = binary.Write(&hs, binary.BigEndian, uint16(2)) // cipher_suites length
+	hs.Write([]byte{0x00, 0x00})                       // one (bogus) cipher suite
+	hs.WriteByte(0x01)                                 // compression_methods length
+	hs.WriteByte(0x00)                                 // null compression
+	_ = binary.Write(&hs, binary.BigEndian, uint16(ext.Len()))
+	hs.Write(ext.Bytes())
+
+	var body bytes.Buffer
+	body.WriteByte(0x01) // handshake type: ClientHello
+	length := hs.Len()
+	body.Write([]byte{byte(length >> 16), byte(length >> 8), byte(length)})
+	body.Write(hs.Bytes())
+
+	var record bytes.Buffer
+	record.WriteByte(0x16)           // content type: handshake
+	record.Write([]byte{0x03, 0x01}) // legacy record version
+	_ = binary.Write(&record, binary.BigEndian, uint16(body.Len()))
+	record.Write(body.Bytes())
+
+	return record.Bytes()
+}
+
+func TestPeekClientHelloSNI(t *testing.T) {
+	tests := []struct {
+		name    string
+		sni     string
+		padLen  int
+		wantSNI string
+	}{
+		{name: "with sni", sni: "tenant-a.db.example.com"},
+		{name: "no sni", sni: ""},
+		{name: "large hello exceeding default 4096 buffer", sni: "tenant-b.db.example.com", padLen: 4500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := buildClientHello(t, tt.sni, tt.padLen)
+			br := bufio.NewReaderSize(bytes.NewReader(record), 4096)
+
+			sni, out, err := peekClientHelloSNI(br)
+			if err != nil {
+				t.Fatalf("peekClientHelloSNI: %v", err)
+			}
+			if sni != tt.sni {
+				t.Errorf("sni = %q, want %q", sni, tt.sni)
+			}
+
+			// The returned reader must still see every byte of the
+			// original record, regardless of whether it had to grow.
+			got, err := out.Peek(len(record))
+			if err != nil {
+				t.Fatalf("Peek on returned reader: %v", err)
+			}
+			if !bytes.Equal(got, record) {
+				t.Errorf("returned reader lost bytes: got %d, want %d", len(got), len(record))
+			}
+		})
+	}
+}
+
+func TestPeekClientHelloSNI_RecordLargerThanDataAvailable(t *testing.T) {
+	// A record claiming the maximum 16-bit length but with only a
+	// handful of bytes actually sent yet should grow the buffer to fit
+	// the claimed length, then fail with a read error (not a panic, and
+	// not a bufio.ErrBufferFull, since the grown buffer is large enough).
+	hdr := []byte{0x16, 0x03, 0x01, 0xFF, 0xFF}
+	br := bufio.NewReaderSize(bytes.NewReader(append(hdr, make([]byte, 100)...)), 16)
+
+	_, _, err := peekClientHelloSNI(br)
+	if err == nil {
+		t.Fatal("expected an error for a record that doesn't fit")
+	}
+	if errors.Is(err, bufio.ErrBufferFull) {
+		t.Fatalf("growing to the protocol maximum should have avoided ErrBufferFull, got: %v", err)
+	}
+}
+
+func TestPeekClientHelloSNI_TruncatedBeforeSessionIDLength(t *testing.T) {
+	// A handshake body that ends right after client_version(2)+random(32),
+	// with no session_id length byte at all, used to index hs[34] on a
+	// 34-byte hs and panic. It must now be reported as a truncated
+	// ClientHello instead.
+	hs := make([]byte, 34) // client_version + random, nothing more
+
+	var body bytes.Buffer
+	body.WriteByte(0x01) // handshake type: ClientHello
+	length := len(hs)
+	body.Write([]byte{byte(length >> 16), byte(length >> 8), byte(length)})
+	body.Write(hs)
+
+	var record bytes.Buffer
+	record.WriteByte(0x16)           // content type: handshake
+	record.Write([]byte{0x03, 0x01}) // legacy record version
+	_ = binary.Write(&record, binary.BigEndian, uint16(body.Len()))
+	record.Write(body.Bytes())
+
+	br := bufio.NewReader(bytes.NewReader(record.Bytes()))
+	sni, _, err := peekClientHelloSNI(br)
+	if err == nil {
+		t.Fatal("expected an error for a ClientHello truncated before session_id length")
+	}
+	if sni != "" {
+		t.Errorf("sni = %q, want empty", sni)
+	}
+}
+
+func TestPeekClientHelloSNI_NotATLSRecord(t *testing.T) {
+	br := bufio.NewReader(bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x08, 4, 210, 22, 47}))
+	sni, _, err := peekClientHelloSNI(br)
+	if sni != "" || err == nil {
+		t.Fatalf("peekClientHelloSNI(non-TLS) = %q, %v; want empty sni and an error", sni, err)
+	}
+	if errors.Is(err, bufio.ErrBufferFull) {
+		t.Fatalf("unexpected buffer-full error for a short, non-TLS buffer: %v", err)
+	}
+}